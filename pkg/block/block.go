@@ -0,0 +1,64 @@
+// Package block holds the on-disk and object-storage representation of a
+// Prometheus TSDB block as produced by the shipper, plus the additional
+// Thanos-specific metadata stored alongside it.
+package block
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb"
+)
+
+// MetaFilename is the known JSON filename for meta information.
+const MetaFilename = "meta.json"
+
+// ThanosMeta holds block meta information specific to Thanos.
+type ThanosMeta struct {
+	// Labels are the external labels identifying the producer of this
+	// block as well as its replication group.
+	Labels map[string]string `json:"labels"`
+}
+
+// Meta is a Thanos-enriched version of tsdb.BlockMeta.
+type Meta struct {
+	tsdb.BlockMeta
+
+	Thanos ThanosMeta `json:"thanos"`
+}
+
+// ReadMetaFile reads the meta file for the given block dir.
+func ReadMetaFile(dir string) (*Meta, error) {
+	b, err := os.ReadFile(filepath.Join(dir, MetaFilename))
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta file")
+	}
+	var m Meta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "unmarshal meta file")
+	}
+	return &m, nil
+}
+
+// WriteMetaFile writes the given meta into <dir>/meta.json.
+func WriteMetaFile(dir string, meta *Meta) error {
+	tmp := filepath.Join(dir, MetaFilename) + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "create tmp meta file")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+
+	if err := enc.Encode(meta); err != nil {
+		f.Close()
+		return errors.Wrap(err, "encode meta")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close tmp meta file")
+	}
+	return os.Rename(tmp, filepath.Join(dir, MetaFilename))
+}
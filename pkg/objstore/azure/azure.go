@@ -0,0 +1,111 @@
+// Package azure implements the objstore.Bucket interface against an Azure
+// Blob Storage container.
+package azure
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/pkg/errors"
+)
+
+// Config holds the parameters needed to talk to an Azure Blob container.
+type Config struct {
+	StorageAccountName string
+	StorageAccountKey  string
+	ContainerName      string
+}
+
+// Bucket implements objstore.Bucket against an Azure Blob container.
+type Bucket struct {
+	container azblob.ContainerURL
+}
+
+// NewBucket returns a new Bucket using the supplied config.
+func NewBucket(conf Config) (*Bucket, error) {
+	cred, err := azblob.NewSharedKeyCredential(conf.StorageAccountName, conf.StorageAccountKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "create shared key credential")
+	}
+	p := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+	u, err := url.Parse("https://" + conf.StorageAccountName + ".blob.core.windows.net/" + conf.ContainerName)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse container URL")
+	}
+	return &Bucket{container: azblob.NewContainerURL(*u, p)}, nil
+}
+
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := b.container.ListBlobsHierarchySegment(ctx, marker, "/", azblob.ListBlobsSegmentOptions{Prefix: dir})
+		if err != nil {
+			return err
+		}
+		for _, p := range resp.Segment.BlobPrefixes {
+			if err := f(p.Name); err != nil {
+				return err
+			}
+		}
+		for _, it := range resp.Segment.BlobItems {
+			if err := f(it.Name); err != nil {
+				return err
+			}
+		}
+		marker = resp.NextMarker
+	}
+	return nil
+}
+
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	blob := b.container.NewBlockBlobURL(name)
+	resp, err := blob.Download(ctx, off, length, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, objstore.ErrNotFound
+		}
+		return nil, err
+	}
+	return resp.Body(azblob.RetryReaderOptions{}), nil
+}
+
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	blob := b.container.NewBlockBlobURL(name)
+	_, err := blob.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err == nil {
+		return true, nil
+	}
+	if isNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	blob := b.container.NewBlockBlobURL(name)
+	_, err := azblob.UploadStreamToBlockBlob(ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{})
+	return err
+}
+
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	blob := b.container.NewBlockBlobURL(name)
+	_, err := blob.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	return err
+}
+
+func (b *Bucket) Close() error { return nil }
+
+func isNotFound(err error) bool {
+	sErr, ok := err.(azblob.StorageError)
+	return ok && sErr.ServiceCode() == azblob.ServiceCodeBlobNotFound
+}
@@ -0,0 +1,126 @@
+// Package filesystem implements the objstore.Bucket interface against a
+// plain directory on the local disk. It has no durability or concurrency
+// guarantees beyond what the OS gives us and exists primarily so unit and
+// e2e tests don't need a real cloud backend to exercise the store gateway.
+package filesystem
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/pkg/errors"
+)
+
+// Bucket implements objstore.Bucket backed by a local directory.
+type Bucket struct {
+	rootDir string
+}
+
+// NewBucket returns a new filesystem.Bucket rooted at dir. The directory is
+// created if it does not exist yet.
+func NewBucket(dir string) (*Bucket, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrap(err, "create root dir")
+	}
+	return &Bucket{rootDir: dir}, nil
+}
+
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	absDir := filepath.Join(b.rootDir, dir)
+	info, err := os.Stat(absDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return errors.Errorf("%q is not a directory", dir)
+	}
+	files, err := ioutil.ReadDir(absDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		name := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			name += "/"
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(b.rootDir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, objstore.ErrNotFound
+		}
+		return nil, err
+	}
+	if off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &limitReadCloser{f: f, r: io.LimitReader(f, length)}, nil
+}
+
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(b.rootDir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	dst := filepath.Join(b.rootDir, name)
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return errors.Wrap(err, "create parent dir")
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrap(err, "copy object")
+	}
+	return f.Close()
+}
+
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(b.rootDir, name))
+}
+
+func (b *Bucket) Close() error { return nil }
+
+// limitReadCloser reads at most the requested range from the underlying
+// file. The file is closed only when the caller calls Close, not when the
+// range has been fully consumed.
+type limitReadCloser struct {
+	f *os.File
+	r io.Reader
+}
+
+func (l *limitReadCloser) Read(p []byte) (int, error) { return l.r.Read(p) }
+func (l *limitReadCloser) Close() error               { return l.f.Close() }
@@ -0,0 +1,99 @@
+// Package gcs implements the objstore.Bucket interface against Google
+// Cloud Storage.
+package gcs
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+// Bucket implements objstore.Bucket against a GCS bucket handle.
+type Bucket struct {
+	bkt *storage.BucketHandle
+}
+
+// NewBucket returns a new Bucket using the given bucket name and a client
+// constructed from the ambient Google Application Default Credentials.
+func NewBucket(ctx context.Context, bucket string) (*Bucket, error) {
+	gcsClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "create GCS client")
+	}
+	return &Bucket{bkt: gcsClient.Bucket(bucket)}, nil
+}
+
+// Handle exposes the underlying GCS bucket handle for callers that need
+// direct access to it.
+func (b *Bucket) Handle() *storage.BucketHandle {
+	return b.bkt
+}
+
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	it := b.bkt.Objects(ctx, &storage.Query{Delimiter: "/", Prefix: dir})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := attrs.Prefix
+		if name == "" {
+			name = attrs.Name
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	r, err := b.bkt.Object(name).NewRangeReader(ctx, off, length)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, objstore.ErrNotFound
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := b.bkt.Object(name).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	w := b.bkt.Object(name).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return errors.Wrap(err, "upload object")
+	}
+	return w.Close()
+}
+
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	return b.bkt.Object(name).Delete(ctx)
+}
+
+func (b *Bucket) Close() error { return nil }
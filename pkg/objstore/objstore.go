@@ -0,0 +1,51 @@
+// Package objstore defines a minimal object-storage interface that the
+// store gateway and shipper use so they do not have to know which
+// concrete backend (GCS, S3, Azure, Swift, or the local filesystem used
+// in tests) they are talking to.
+package objstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Bucket provides read and write access to an object storage bucket.
+// It is deliberately small so that new backends are cheap to add.
+type Bucket interface {
+	BucketReader
+
+	// Upload the contents of the reader as an object into the bucket.
+	Upload(ctx context.Context, name string, r io.Reader) error
+
+	// Delete removes the object with the given name from the bucket.
+	Delete(ctx context.Context, name string) error
+}
+
+// BucketReader provides read access to an object storage bucket.
+type BucketReader interface {
+	// Iter calls f for each entry in the given directory. The argument to f
+	// is the full object name including the prefix of the inspected directory.
+	Iter(ctx context.Context, dir string, f func(string) error) error
+
+	// Get returns a reader for the given object name.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// GetRange returns a new range reader for the given object name and range.
+	GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error)
+
+	// Exists checks if the given object exists in the bucket.
+	Exists(ctx context.Context, name string) (bool, error)
+}
+
+// ErrNotFound is returned, possibly wrapped via github.com/pkg/errors, by
+// BucketReader implementations when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// IsObjNotFoundErr returns true if the error indicates that the object was
+// not found in the bucket. Each backend is expected to wrap its
+// not-found condition with ErrNotFound so callers stay backend-agnostic.
+func IsObjNotFoundErr(err error) bool {
+	return errors.Cause(err) == ErrNotFound
+}
@@ -0,0 +1,84 @@
+// Package objtesting lets tests exercise the same assertions against every
+// objstore.Bucket backend instead of duplicating the test body per
+// backend.
+package objtesting
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/azure"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
+	"github.com/improbable-eng/thanos/pkg/objstore/gcs"
+	"github.com/improbable-eng/thanos/pkg/objstore/s3"
+	"github.com/improbable-eng/thanos/pkg/objstore/swift"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+// ForeachStore runs testFn against every backend configured for the test
+// run. The filesystem backend always runs; the cloud backends only run
+// when the corresponding THANOS_TEST_OBJSTORE_* environment variables are
+// set, since they require live credentials.
+func ForeachStore(t *testing.T, testFn func(t *testing.T, bkt objstore.Bucket)) {
+	t.Helper()
+
+	t.Run("filesystem", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "test_filesystem_bucket")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(dir)
+
+		bkt, err := filesystem.NewBucket(dir)
+		testutil.Ok(t, err)
+		testFn(t, bkt)
+	})
+
+	if bucket := os.Getenv("THANOS_TEST_OBJSTORE_GCS_BUCKET"); bucket != "" {
+		t.Run("gcs", func(t *testing.T) {
+			bkt, err := gcs.NewBucket(context.Background(), bucket)
+			testutil.Ok(t, err)
+			testFn(t, bkt)
+		})
+	}
+
+	if bucket := os.Getenv("THANOS_TEST_OBJSTORE_S3_BUCKET"); bucket != "" {
+		t.Run("s3", func(t *testing.T) {
+			bkt, err := s3.NewBucket(s3.Config{
+				Bucket:    bucket,
+				Endpoint:  os.Getenv("THANOS_TEST_OBJSTORE_S3_ENDPOINT"),
+				AccessKey: os.Getenv("THANOS_TEST_OBJSTORE_S3_ACCESS_KEY"),
+				SecretKey: os.Getenv("THANOS_TEST_OBJSTORE_S3_SECRET_KEY"),
+			})
+			testutil.Ok(t, err)
+			testFn(t, bkt)
+		})
+	}
+
+	if container := os.Getenv("THANOS_TEST_OBJSTORE_AZURE_CONTAINER"); container != "" {
+		t.Run("azure", func(t *testing.T) {
+			bkt, err := azure.NewBucket(azure.Config{
+				StorageAccountName: os.Getenv("THANOS_TEST_OBJSTORE_AZURE_ACCOUNT"),
+				StorageAccountKey:  os.Getenv("THANOS_TEST_OBJSTORE_AZURE_KEY"),
+				ContainerName:      container,
+			})
+			testutil.Ok(t, err)
+			testFn(t, bkt)
+		})
+	}
+
+	if container := os.Getenv("THANOS_TEST_OBJSTORE_SWIFT_CONTAINER"); container != "" {
+		t.Run("swift", func(t *testing.T) {
+			bkt, err := swift.NewBucket(swift.Config{
+				AuthURL:       os.Getenv("THANOS_TEST_OBJSTORE_SWIFT_AUTH_URL"),
+				Username:      os.Getenv("THANOS_TEST_OBJSTORE_SWIFT_USERNAME"),
+				Password:      os.Getenv("THANOS_TEST_OBJSTORE_SWIFT_PASSWORD"),
+				Tenant:        os.Getenv("THANOS_TEST_OBJSTORE_SWIFT_TENANT"),
+				ContainerName: container,
+			})
+			testutil.Ok(t, err)
+			testFn(t, bkt)
+		})
+	}
+}
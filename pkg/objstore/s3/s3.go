@@ -0,0 +1,117 @@
+// Package s3 implements the objstore.Bucket interface against an
+// S3-compatible object store using the minio client.
+package s3
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/minio/minio-go"
+	"github.com/pkg/errors"
+)
+
+// Config holds the parameters needed to talk to an S3-compatible endpoint.
+type Config struct {
+	Bucket    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+	SignatureV2 bool
+}
+
+// Bucket implements objstore.Bucket against an S3 bucket.
+type Bucket struct {
+	client *minio.Client
+	name   string
+}
+
+// NewBucket returns a new Bucket using the supplied config.
+func NewBucket(conf Config) (*Bucket, error) {
+	var (
+		client *minio.Client
+		err    error
+	)
+	if conf.SignatureV2 {
+		client, err = minio.NewV2(conf.Endpoint, conf.AccessKey, conf.SecretKey, !conf.Insecure)
+	} else {
+		client, err = minio.NewV4(conf.Endpoint, conf.AccessKey, conf.SecretKey, !conf.Insecure)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "create minio client")
+	}
+	return &Bucket{client: client, name: conf.Bucket}, nil
+}
+
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	done := ctx.Done()
+	for obj := range b.client.ListObjects(b.name, dir, false, done) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if err := f(obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if length >= 0 {
+		if err := opts.SetRange(off, off+length-1); err != nil {
+			return nil, err
+		}
+	} else if off > 0 {
+		if err := opts.SetRange(off, 0); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := b.client.GetObject(b.name, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		if isNotFound(err) {
+			return nil, objstore.ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := b.client.StatObject(b.name, name, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if isNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	_, err := b.client.PutObject(b.name, name, r, -1, minio.PutObjectOptions{})
+	return err
+}
+
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	return b.client.RemoveObject(b.name, name)
+}
+
+func (b *Bucket) Close() error { return nil }
+
+func isNotFound(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.Code == "NoSuchKey"
+}
@@ -0,0 +1,111 @@
+// Package swift implements the objstore.Bucket interface against an
+// OpenStack Swift container.
+package swift
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/ncw/swift"
+	"github.com/pkg/errors"
+)
+
+// Config holds the parameters needed to talk to a Swift container.
+type Config struct {
+	AuthURL       string
+	Username      string
+	Password      string
+	Tenant        string
+	ContainerName string
+}
+
+// Bucket implements objstore.Bucket against a Swift container.
+type Bucket struct {
+	conn      *swift.Connection
+	container string
+}
+
+// NewBucket returns a new Bucket using the supplied config.
+func NewBucket(conf Config) (*Bucket, error) {
+	c := &swift.Connection{
+		AuthUrl:  conf.AuthURL,
+		UserName: conf.Username,
+		ApiKey:   conf.Password,
+		Tenant:   conf.Tenant,
+	}
+	if err := c.Authenticate(); err != nil {
+		return nil, errors.Wrap(err, "authenticate with swift")
+	}
+	return &Bucket{conn: c, container: conf.ContainerName}, nil
+}
+
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return b.conn.ObjectsWalk(b.container, &swift.ObjectsOpts{Prefix: dir, Delimiter: '/'}, func(opts *swift.ObjectsOpts) (interface{}, error) {
+		names, err := b.conn.ObjectNames(b.container, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if err := f(n); err != nil {
+				return nil, err
+			}
+		}
+		return names, nil
+	})
+}
+
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+func (b *Bucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	headers := swift.Headers{}
+	if length >= 0 {
+		headers["Range"] = swift.FormatRange(off, off+length-1)
+	} else if off > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", off)
+	}
+	f, _, err := b.conn.ObjectOpen(b.container, name, false, headers)
+	if err != nil {
+		if err == swift.ObjectNotFound {
+			return nil, objstore.ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	_, _, err := b.conn.Object(b.container, name)
+	if err == swift.ObjectNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	w, err := b.conn.ObjectCreate(b.container, name, false, "", "", nil)
+	if err != nil {
+		return errors.Wrap(err, "create object")
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return errors.Wrap(err, "upload object")
+	}
+	return w.Close()
+}
+
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	return b.conn.ObjectDelete(b.container, name)
+}
+
+func (b *Bucket) Close() error { return nil }
@@ -0,0 +1,77 @@
+// Package pool implements a bucketed pool of byte slices so hot paths that
+// need many short-lived buffers of varying size (e.g. object-storage range
+// fetches) don't put constant pressure on the allocator and GC.
+package pool
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BytesPool is a pool of []byte buckets, each sized in power-of-two steps
+// between minSize and maxSize. Get rounds a request up to the next bucket
+// size; Put returns a buffer to its bucket for reuse.
+type BytesPool struct {
+	buckets []sync.Pool
+	sizes   []int
+	maxSize int
+	hits    prometheus.Counter
+	misses  prometheus.Counter
+}
+
+// NewBytesPool returns a new BytesPool with buckets of size minSize,
+// minSize*2, minSize*4, ... up to and including maxSize. minSize and
+// maxSize must both be powers of two with minSize <= maxSize. hits and
+// misses may be nil, in which case the pool simply doesn't record them.
+func NewBytesPool(minSize, maxSize int, hits, misses prometheus.Counter) (*BytesPool, error) {
+	if minSize <= 0 || maxSize <= 0 || minSize > maxSize {
+		return nil, errors.Errorf("invalid pool size range [%d,%d]", minSize, maxSize)
+	}
+	p := &BytesPool{maxSize: maxSize, hits: hits, misses: misses}
+	for s := minSize; s <= maxSize; s *= 2 {
+		p.sizes = append(p.sizes, s)
+		p.buckets = append(p.buckets, sync.Pool{})
+	}
+	return p, nil
+}
+
+// Get returns a buffer with capacity of at least sz. If sz exceeds the
+// pool's largest bucket, a plain slice is allocated and not returned to
+// any pool on Put.
+func (p *BytesPool) Get(sz int) []byte {
+	for i, bucketSize := range p.sizes {
+		if sz > bucketSize {
+			continue
+		}
+		if b, ok := p.buckets[i].Get().([]byte); ok {
+			p.inc(p.hits)
+			return b[:0]
+		}
+		p.inc(p.misses)
+		return make([]byte, 0, bucketSize)
+	}
+	p.inc(p.misses)
+	return make([]byte, 0, sz)
+}
+
+func (p *BytesPool) inc(c prometheus.Counter) {
+	if c != nil {
+		c.Inc()
+	}
+}
+
+// Put returns b to the pool bucket that fits its capacity, if any.
+func (p *BytesPool) Put(b []byte) {
+	c := cap(b)
+	if c == 0 || c > p.maxSize {
+		return
+	}
+	for i, bucketSize := range p.sizes {
+		if c <= bucketSize {
+			p.buckets[i].Put(b) //nolint:staticcheck // intentional: reuse caller's backing array.
+			return
+		}
+	}
+}
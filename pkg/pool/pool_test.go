@@ -0,0 +1,50 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBytesPool_Get(t *testing.T) {
+	p, err := NewBytesPool(2, 16, nil, nil)
+	testutil.Ok(t, err)
+
+	for _, sz := range []int{1, 2, 3, 16, 17} {
+		b := p.Get(sz)
+		testutil.Assert(t, cap(b) >= sz || sz > 16, "buffer too small for requested size %d", sz)
+		testutil.Equals(t, 0, len(b))
+		p.Put(b)
+	}
+}
+
+func TestBytesPool_reuse(t *testing.T) {
+	p, err := NewBytesPool(2, 16, nil, nil)
+	testutil.Ok(t, err)
+
+	b := p.Get(4)
+	b = append(b, 1, 2, 3, 4)
+	p.Put(b)
+
+	b2 := p.Get(4)
+	testutil.Equals(t, 0, len(b2))
+	testutil.Assert(t, cap(b2) >= 4, "expected reused buffer to retain capacity")
+}
+
+func TestBytesPool_metrics(t *testing.T) {
+	hits := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_pool_hits"})
+	misses := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_pool_misses"})
+	p, err := NewBytesPool(2, 16, hits, misses)
+	testutil.Ok(t, err)
+
+	b := p.Get(4)
+	testutil.Equals(t, float64(0), prom_testutil.ToFloat64(hits))
+	testutil.Equals(t, float64(1), prom_testutil.ToFloat64(misses))
+
+	p.Put(b)
+	p.Get(4)
+	testutil.Equals(t, float64(1), prom_testutil.ToFloat64(hits))
+	testutil.Equals(t, float64(1), prom_testutil.ToFloat64(misses))
+}
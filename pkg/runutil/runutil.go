@@ -0,0 +1,44 @@
+// Package runutil provides helpers to run functions in a repeated or
+// retriable fashion against a done channel, as used by the various
+// background sync loops across the project.
+package runutil
+
+import "time"
+
+// Repeat executes f every interval until stopc is closed or f returns an
+// error. It executes f once right before the first tick.
+func Repeat(interval time.Duration, stopc <-chan struct{}, f func() error) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		if err := f(); err != nil {
+			return err
+		}
+		select {
+		case <-stopc:
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+// Retry executes f every interval until it returns no error or stopc is
+// closed, in which case it returns f's last error. When stopc is a
+// context's Done channel, the context's deadline closes it.
+func Retry(interval time.Duration, stopc <-chan struct{}, f func() error) error {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	var err error
+	for {
+		if err = f(); err == nil {
+			return nil
+		}
+		select {
+		case <-stopc:
+			return err
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package shipper ships local TSDB blocks produced by Prometheus to an
+// object storage bucket so they become visible to the store gateway.
+package shipper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+)
+
+// Shipper watches a data directory for finished TSDB blocks and uploads
+// them to an object storage bucket keyed by backend-agnostic Bucket
+// implementation.
+type Shipper struct {
+	logger log.Logger
+	bucket objstore.Bucket
+	dir    string
+	labels func() map[string]string
+}
+
+// New creates a new shipper that uploads blocks found in dir to bucket.
+// labels, if not nil, is used to label blocks with the given external
+// labels on upload.
+func New(logger log.Logger, bucket objstore.Bucket, dir string, labels func() map[string]string) *Shipper {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if labels == nil {
+		labels = func() map[string]string { return nil }
+	}
+	return &Shipper{
+		logger: logger,
+		bucket: bucket,
+		dir:    dir,
+		labels: labels,
+	}
+}
+
+// Sync uploads all local blocks under the shipper's data dir that are not
+// yet present in the bucket.
+func (s *Shipper) Sync(ctx context.Context) error {
+	fis, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return errors.Wrap(err, "read dir")
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		id, err := ulid.Parse(fi.Name())
+		if err != nil {
+			continue
+		}
+		blockDir := filepath.Join(s.dir, fi.Name())
+
+		ok, err := s.bucket.Exists(ctx, filepath.Join(id.String(), block.MetaFilename))
+		if err != nil {
+			return errors.Wrap(err, "check if block already uploaded")
+		}
+		if ok {
+			continue
+		}
+		if err := s.Upload(ctx, id, blockDir); err != nil {
+			level.Warn(s.logger).Log("msg", "upload failed", "block", id, "err", err)
+		}
+	}
+	return nil
+}
+
+// Upload uploads the block at dir, identified by id, into the bucket. The
+// meta file is stamped with the shipper's external labels before upload so
+// any replica or tenant information travels with the block. Labels already
+// present in the block's meta.json (e.g. stamped by an earlier step) are
+// kept; the shipper's labels are merged in on top, overriding only on key
+// collision.
+func (s *Shipper) Upload(ctx context.Context, id ulid.ULID, dir string) error {
+	meta, err := block.ReadMetaFile(dir)
+	if err != nil {
+		return errors.Wrap(err, "read meta file")
+	}
+	if labels := s.labels(); len(labels) > 0 {
+		if meta.Thanos.Labels == nil {
+			meta.Thanos.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			meta.Thanos.Labels[k] = v
+		}
+	}
+
+	if err := block.WriteMetaFile(dir, meta); err != nil {
+		return errors.Wrap(err, "stamp external labels")
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "open file")
+		}
+		defer f.Close()
+
+		if err := s.bucket.Upload(ctx, filepath.Join(id.String(), rel), f); err != nil {
+			return errors.Wrapf(err, "upload %s", rel)
+		}
+		return nil
+	})
+}
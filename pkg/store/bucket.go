@@ -0,0 +1,746 @@
+// Package store implements the storepb.Store gRPC service used by the
+// store gateway to serve time series that live in object storage.
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/pool"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+)
+
+const (
+	// Chunk buffers are pooled in power-of-two buckets from 64KiB to 64MiB.
+	chunkPoolMinSize = 64 * 1024
+	chunkPoolMaxSize = 64 * 1024 * 1024
+
+	// maxGapSize is the largest gap between two chunk ranges in the same
+	// segment file that we'll still bridge with a single GetRange call
+	// rather than issuing two.
+	maxGapSize = 512 * 1024
+)
+
+// GossipFunc is called whenever the set of loaded blocks changes so the
+// caller can advertise the covered time range, e.g. via gossip.
+type GossipFunc func(mint, maxt int64)
+
+// BucketStore implements the store API against a single object storage
+// bucket containing one or more TSDB blocks uploaded by the shipper. It is
+// backend-agnostic: any objstore.Bucket implementation (GCS, S3, Azure,
+// Swift, or the local filesystem used in tests) works.
+//
+// A BucketStore only loads blocks that its ShardFunc claims, so that
+// several BucketStore replicas can be pointed at the same bucket and split
+// the set of blocks between them instead of every replica serving
+// everything.
+type BucketStore struct {
+	logger log.Logger
+	bucket objstore.Bucket
+	dir    string
+	gossip GossipFunc
+	shard  ShardFunc
+
+	mtx     sync.RWMutex
+	blocks  map[ulid.ULID]*bucketBlock
+	ignored map[ulid.ULID]struct{} // blocks seen in the bucket but not owned by shard
+
+	chunkPool *pool.BytesPool
+	metrics   *bucketStoreMetrics
+}
+
+type bucketStoreMetrics struct {
+	blocksLoaded           prometheus.Gauge
+	chunkPoolHits          prometheus.Counter
+	chunkPoolMisses        prometheus.Counter
+	chunkBytesFetched      prometheus.Counter
+	rangeRequestsIssued    prometheus.Counter
+	rangeRequestsCoalesced prometheus.Counter
+}
+
+func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
+	m := &bucketStoreMetrics{
+		blocksLoaded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_bucket_store_blocks_loaded",
+			Help: "Number of currently loaded blocks.",
+		}),
+		chunkPoolHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_store_chunk_pool_hits_total",
+			Help: "Number of chunk buffer requests served from the pool.",
+		}),
+		chunkPoolMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_store_chunk_pool_misses_total",
+			Help: "Number of chunk buffer requests that required a new allocation.",
+		}),
+		chunkBytesFetched: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_store_chunk_bytes_fetched_total",
+			Help: "Total bytes fetched from object storage for chunk data.",
+		}),
+		rangeRequestsIssued: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_store_range_requests_total",
+			Help: "Number of GetRange calls issued against the bucket for chunk data.",
+		}),
+		rangeRequestsCoalesced: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_store_range_requests_coalesced_total",
+			Help: "Number of individual chunk ranges that were merged into a bigger GetRange call instead of being fetched separately.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(
+			m.blocksLoaded,
+			m.chunkPoolHits,
+			m.chunkPoolMisses,
+			m.chunkBytesFetched,
+			m.rangeRequestsIssued,
+			m.rangeRequestsCoalesced,
+		)
+	}
+	return m
+}
+
+// NewBucketStore creates a new BucketStore backed by bucket. dir is used as
+// a local cache directory for downloaded block indexes. shard decides which
+// blocks this instance owns; a nil shard makes it own every block, which is
+// the right choice unless multiple BucketStore replicas share the bucket.
+func NewBucketStore(logger log.Logger, reg prometheus.Registerer, bucket objstore.Bucket, gossip GossipFunc, dir string, shard ShardFunc) (*BucketStore, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if gossip == nil {
+		gossip = func(int64, int64) {}
+	}
+	if shard == nil {
+		shard = AllShardsFunc
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrap(err, "create cache dir")
+	}
+	metrics := newBucketStoreMetrics(reg)
+
+	chunkPool, err := pool.NewBytesPool(chunkPoolMinSize, chunkPoolMaxSize, metrics.chunkPoolHits, metrics.chunkPoolMisses)
+	if err != nil {
+		return nil, errors.Wrap(err, "create chunk pool")
+	}
+	return &BucketStore{
+		logger:    logger,
+		bucket:    bucket,
+		dir:       dir,
+		gossip:    gossip,
+		shard:     shard,
+		blocks:    map[ulid.ULID]*bucketBlock{},
+		ignored:   map[ulid.ULID]struct{}{},
+		chunkPool: chunkPool,
+		metrics:   metrics,
+	}, nil
+}
+
+func (s *BucketStore) numBlocks() int {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return len(s.blocks)
+}
+
+// SyncBlocks scans the bucket for new blocks and loads the ones owned by
+// s.shard, and drops blocks that have disappeared from the bucket. Blocks
+// the shard doesn't own are remembered in s.ignored so they aren't
+// re-fetched on every sync.
+func (s *BucketStore) SyncBlocks(ctx context.Context) error {
+	seen := map[ulid.ULID]struct{}{}
+
+	err := s.bucket.Iter(ctx, "", func(name string) error {
+		id, ok := parseULID(name)
+		if !ok {
+			return nil
+		}
+		seen[id] = struct{}{}
+
+		s.mtx.RLock()
+		_, loaded := s.blocks[id]
+		_, ignored := s.ignored[id]
+		s.mtx.RUnlock()
+		if loaded || ignored {
+			return nil
+		}
+
+		dir := filepath.Join(s.dir, id.String())
+
+		meta, err := fetchBlockMeta(ctx, s.bucket, id, dir)
+		if err != nil {
+			return errors.Wrapf(err, "fetch meta for block %s", id)
+		}
+		if !s.shard(meta) {
+			os.RemoveAll(dir)
+			s.mtx.Lock()
+			s.ignored[id] = struct{}{}
+			s.mtx.Unlock()
+			return nil
+		}
+
+		b, err := newBucketBlock(ctx, s.logger, s.bucket, id, dir, s.chunkPool, s.metrics)
+		if err != nil {
+			return errors.Wrapf(err, "load block %s", id)
+		}
+		s.mtx.Lock()
+		s.blocks[id] = b
+		s.mtx.Unlock()
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "iter bucket")
+	}
+
+	s.mtx.Lock()
+	for id, b := range s.blocks {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		if err := b.Close(); err != nil {
+			level.Warn(s.logger).Log("msg", "close dropped block", "block", id, "err", err)
+		}
+		delete(s.blocks, id)
+		os.RemoveAll(filepath.Join(s.dir, id.String()))
+	}
+	for id := range s.ignored {
+		if _, ok := seen[id]; !ok {
+			delete(s.ignored, id)
+		}
+	}
+	s.metrics.blocksLoaded.Set(float64(len(s.blocks)))
+
+	// mint/maxt only ever range over s.blocks, so a replica only ever
+	// gossips the time range of the blocks it actually owns and serves.
+	var mint, maxt int64 = 0, 0
+	for _, b := range s.blocks {
+		if mint == 0 || b.meta.MinTime < mint {
+			mint = b.meta.MinTime
+		}
+		if b.meta.MaxTime > maxt {
+			maxt = b.meta.MaxTime
+		}
+	}
+	s.mtx.Unlock()
+
+	s.gossip(mint, maxt)
+	return nil
+}
+
+// fetchBlockMeta downloads just the meta.json for block id into dir and
+// parses it. It's kept separate from newBucketBlock so SyncBlocks can
+// decide shard ownership before paying for the much larger index download.
+func fetchBlockMeta(ctx context.Context, bucket objstore.Bucket, id ulid.ULID, dir string) (*block.Meta, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrap(err, "create block dir")
+	}
+	if err := downloadObject(ctx, bucket, filepath.Join(id.String(), block.MetaFilename), filepath.Join(dir, block.MetaFilename)); err != nil {
+		return nil, errors.Wrap(err, "download meta")
+	}
+	meta, err := block.ReadMetaFile(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta")
+	}
+	return meta, nil
+}
+
+func parseULID(name string) (ulid.ULID, bool) {
+	name = filepath.Clean(name)
+	id, err := ulid.Parse(filepath.Base(name))
+	if err != nil {
+		return ulid.ULID{}, false
+	}
+	return id, true
+}
+
+// Series implements storepb.StoreServer.
+func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	matchers, err := translateMatchers(req.Matchers)
+	if err != nil {
+		return errors.Wrap(err, "translate matchers")
+	}
+
+	s.mtx.RLock()
+	blocks := make([]*bucketBlock, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		blocks = append(blocks, b)
+	}
+	s.mtx.RUnlock()
+
+	for _, b := range blocks {
+		blockMatchers, ok := b.blockMatchers(req.MinTime, req.MaxTime, matchers...)
+		if !ok {
+			continue
+		}
+		series, err := b.Series(srv.Context(), blockMatchers, req.MinTime, req.MaxTime)
+		if err != nil {
+			return errors.Wrapf(err, "query block %s", b.meta.ULID)
+		}
+		for _, ser := range series {
+			if err := srv.Send(&storepb.SeriesResponse{Series: ser}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LabelNames implements storepb.StoreServer. For each block whose time
+// range and external labels are compatible with the request, it resolves
+// the matchers against that block's postings first and only then collects
+// the label names occurring on the resulting series, rather than resolving
+// every label value for every name.
+func (s *BucketStore) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	matchers, err := translateMatchers(req.Matchers)
+	if err != nil {
+		return nil, errors.Wrap(err, "translate matchers")
+	}
+
+	s.mtx.RLock()
+	blocks := make([]*bucketBlock, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		blocks = append(blocks, b)
+	}
+	s.mtx.RUnlock()
+
+	names := map[string]struct{}{}
+	for _, b := range blocks {
+		blockMatchers, ok := b.blockMatchers(req.MinTime, req.MaxTime, matchers...)
+		if !ok {
+			continue
+		}
+		ns, err := b.LabelNames(blockMatchers)
+		if err != nil {
+			return nil, errors.Wrapf(err, "label names for block %s", b.meta.ULID)
+		}
+		for _, n := range ns {
+			names[n] = struct{}{}
+		}
+		for n := range b.meta.Thanos.Labels {
+			names[n] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(names))
+	for n := range names {
+		res = append(res, n)
+	}
+	sortStrings(res)
+	return &storepb.LabelNamesResponse{Names: res}, nil
+}
+
+// LabelValues implements storepb.StoreServer.
+func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	s.mtx.RLock()
+	blocks := make([]*bucketBlock, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		blocks = append(blocks, b)
+	}
+	s.mtx.RUnlock()
+
+	values := map[string]struct{}{}
+	for _, b := range blocks {
+		if v, ok := b.meta.Thanos.Labels[req.Label]; ok {
+			values[v] = struct{}{}
+			continue
+		}
+		vs, err := b.LabelValues(req.Label)
+		if err != nil {
+			return nil, errors.Wrapf(err, "label values for block %s", b.meta.ULID)
+		}
+		for _, v := range vs {
+			values[v] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(values))
+	for v := range values {
+		res = append(res, v)
+	}
+	sortStrings(res)
+	return &storepb.LabelValuesResponse{Values: res}, nil
+}
+
+func translateMatchers(ms []storepb.LabelMatcher) ([]labels.Matcher, error) {
+	res := make([]labels.Matcher, 0, len(ms))
+	for _, m := range ms {
+		switch m.Type {
+		case storepb.LabelMatcher_EQ:
+			res = append(res, labels.NewEqualMatcher(m.Name, m.Value))
+		case storepb.LabelMatcher_NEQ:
+			res = append(res, labels.Not(labels.NewEqualMatcher(m.Name, m.Value)))
+		case storepb.LabelMatcher_RE:
+			re, err := labels.NewRegexpMatcher(m.Value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, labels.NewMatcher(labels.MatchRegexp, m.Name, m.Value, re))
+		case storepb.LabelMatcher_NRE:
+			re, err := labels.NewRegexpMatcher(m.Value)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, labels.Not(labels.NewMatcher(labels.MatchRegexp, m.Name, m.Value, re)))
+		default:
+			return nil, errors.Errorf("unknown matcher type %v", m.Type)
+		}
+	}
+	return res, nil
+}
+
+// bucketBlock represents a single TSDB block held in object storage. Its
+// index is downloaded and cached locally on load; chunk data is fetched
+// on demand via range requests against the bucket.
+type bucketBlock struct {
+	logger log.Logger
+	bucket objstore.Bucket
+	dir    string
+	meta   *block.Meta
+
+	// mtx guards indexr against a concurrent Close. LabelValues, LabelNames
+	// and Series hold a read lock for their full duration so SyncBlocks
+	// can't close the index reader and remove the block's directory out
+	// from under an in-flight query; Close takes the write lock so it
+	// waits for any of them already running to finish first. Only these
+	// public entry points lock it -- shared helpers like
+	// postingsForMatchers must not, since taking the read lock twice on
+	// the same goroutine could deadlock against a Close waiting to acquire
+	// the write lock in between.
+	mtx    sync.RWMutex
+	indexr *index.Reader
+
+	chunkPool *pool.BytesPool
+	metrics   *bucketStoreMetrics
+}
+
+func newBucketBlock(ctx context.Context, logger log.Logger, bucket objstore.Bucket, id ulid.ULID, dir string, chunkPool *pool.BytesPool, metrics *bucketStoreMetrics) (*bucketBlock, error) {
+	meta, err := fetchBlockMeta(ctx, bucket, id, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := downloadObject(ctx, bucket, filepath.Join(id.String(), "index"), filepath.Join(dir, "index")); err != nil {
+		return nil, errors.Wrap(err, "download index")
+	}
+	indexr, err := index.NewFileReader(filepath.Join(dir, "index"))
+	if err != nil {
+		return nil, errors.Wrap(err, "open index reader")
+	}
+	return &bucketBlock{
+		logger:    logger,
+		bucket:    bucket,
+		dir:       dir,
+		meta:      meta,
+		indexr:    indexr,
+		chunkPool: chunkPool,
+		metrics:   metrics,
+	}, nil
+}
+
+func (b *bucketBlock) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.indexr.Close()
+}
+
+// blockMatchers checks whether the block may contain data for the
+// [mint,maxt] range and the external-label-resolvable subset of matchers.
+// It returns the matchers that still need to be evaluated against the
+// block's own TSDB index, i.e. those not already satisfied by the block's
+// external labels.
+func (b *bucketBlock) blockMatchers(mint, maxt int64, matchers ...labels.Matcher) ([]labels.Matcher, bool) {
+	if b.meta.MaxTime < mint || b.meta.MinTime > maxt {
+		return nil, false
+	}
+	var res []labels.Matcher
+	for _, m := range matchers {
+		v, ok := b.meta.Thanos.Labels[m.Name()]
+		if !ok {
+			res = append(res, m)
+			continue
+		}
+		if !m.Matches(v) {
+			return nil, false
+		}
+	}
+	return res, true
+}
+
+func (b *bucketBlock) LabelValues(name string) ([]string, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	tpls, err := b.indexr.LabelValues(name)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, tpls.Len())
+	for i := 0; i < tpls.Len(); i++ {
+		v, err := tpls.At(i)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v[0])
+	}
+	return res, nil
+}
+
+// LabelNames returns the set of label names occurring on series selected by
+// matchers. It intersects postings for the matchers first and then, for
+// each resulting series, reads that series' label set from the index and
+// keeps the names. It does not consult the per-name value lists that
+// LabelValues reads, but it does decode each selected series' label values
+// along with its names, since index.Reader.Series resolves both.
+func (b *bucketBlock) LabelNames(matchers []labels.Matcher) ([]string, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	p, err := b.postingsForMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]struct{}{}
+	var lset labels.Labels
+	for p.Next() {
+		lset = lset[:0]
+		if err := b.indexr.Series(p.At(), &lset, nil); err != nil {
+			return nil, err
+		}
+		for _, l := range lset {
+			names[l.Name] = struct{}{}
+		}
+	}
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	res := make([]string, 0, len(names))
+	for n := range names {
+		res = append(res, n)
+	}
+	sortStrings(res)
+	return res, nil
+}
+
+func (b *bucketBlock) postingsForMatchers(matchers []labels.Matcher) (index.Postings, error) {
+	var its []index.Postings
+	for _, m := range matchers {
+		vals, err := b.indexr.LabelValues(m.Name())
+		if err != nil {
+			return nil, err
+		}
+		var matched []string
+		for i := 0; i < vals.Len(); i++ {
+			v, err := vals.At(i)
+			if err != nil {
+				return nil, err
+			}
+			if m.Matches(v[0]) {
+				matched = append(matched, v[0])
+			}
+		}
+		if len(matched) == 0 {
+			return index.EmptyPostings(), nil
+		}
+		p, err := b.indexr.Postings(m.Name(), matched...)
+		if err != nil {
+			return nil, err
+		}
+		its = append(its, p)
+	}
+	if len(its) == 0 {
+		return b.indexr.Postings("", "")
+	}
+	return index.Intersect(its...), nil
+}
+
+func (b *bucketBlock) Series(ctx context.Context, matchers []labels.Matcher, mint, maxt int64) ([]storepb.Series, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	p, err := b.postingsForMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		res      []storepb.Series
+		wantChks []chunkTarget
+		lset     labels.Labels
+		chks     []chunks.Meta
+	)
+	for p.Next() {
+		lset, chks = lset[:0], chks[:0]
+		if err := b.indexr.Series(p.At(), &lset, &chks); err != nil {
+			return nil, err
+		}
+		s := storepb.Series{Labels: toStorepbLabels(lset, b.meta.Thanos.Labels)}
+		for _, c := range chks {
+			if c.MaxTime < mint || c.MinTime > maxt {
+				continue
+			}
+			s.Chunks = append(s.Chunks, storepb.Chunk{MinTime: c.MinTime, MaxTime: c.MaxTime})
+			wantChks = append(wantChks, chunkTarget{ref: c.Ref, seriesIdx: len(res), chunkIdx: len(s.Chunks) - 1})
+		}
+		if len(s.Chunks) > 0 {
+			res = append(res, s)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	if err := b.loadChunks(ctx, wantChks, res); err != nil {
+		return nil, errors.Wrap(err, "load chunks")
+	}
+	return res, nil
+}
+
+// chunkTarget records where the bytes for a single wanted chunk need to
+// end up once fetched: series[seriesIdx].Chunks[chunkIdx].
+type chunkTarget struct {
+	ref       uint64
+	seriesIdx int
+	chunkIdx  int
+}
+
+// loadChunks fetches the raw bytes for every chunk in want and fills them
+// into the corresponding storepb.Chunk slots across series. It groups
+// chunks by segment file and coalesces range requests that are adjacent
+// or within maxGapSize of each other into a single GetRange call, so a
+// query touching many chunks in the same segment doesn't pay for a
+// network round trip per chunk.
+func (b *bucketBlock) loadChunks(ctx context.Context, want []chunkTarget, series []storepb.Series) error {
+	bySegment := map[string][]chunkTarget{}
+	for _, t := range want {
+		seg := chunkSegmentFile(b.meta.ULID.String(), t.ref)
+		bySegment[seg] = append(bySegment[seg], t)
+	}
+
+	for seg, targets := range bySegment {
+		sort.Slice(targets, func(i, j int) bool {
+			return chunkSegmentOffset(targets[i].ref) < chunkSegmentOffset(targets[j].ref)
+		})
+
+		for start := 0; start < len(targets); {
+			end := start + 1
+			groupEnd := chunkSegmentOffset(targets[start].ref) + maxChunkLen
+			for end < len(targets) && chunkSegmentOffset(targets[end].ref)-groupEnd <= maxGapSize {
+				groupEnd = chunkSegmentOffset(targets[end].ref) + maxChunkLen
+				end++
+			}
+			group := targets[start:end]
+			if len(group) > 1 {
+				b.metrics.rangeRequestsCoalesced.Add(float64(len(group) - 1))
+			}
+
+			off := chunkSegmentOffset(group[0].ref)
+			length := groupEnd - off
+
+			buf := b.chunkPool.Get(int(length))
+			buf = buf[:length]
+
+			b.metrics.rangeRequestsIssued.Inc()
+			n, err := b.fetchRange(ctx, seg, off, buf)
+			if err != nil {
+				b.chunkPool.Put(buf)
+				return err
+			}
+			buf = buf[:n]
+			b.metrics.chunkBytesFetched.Add(float64(n))
+
+			for _, t := range group {
+				relOff := chunkSegmentOffset(t.ref) - off
+				end := relOff + maxChunkLen
+				if end > int64(len(buf)) {
+					end = int64(len(buf))
+				}
+				data := make([]byte, end-relOff)
+				copy(data, buf[relOff:end])
+				series[t.seriesIdx].Chunks[t.chunkIdx].Data = data
+			}
+			b.chunkPool.Put(buf)
+
+			start = end
+		}
+	}
+	return nil
+}
+
+// fetchRange reads up to len(buf) bytes at off from segFile into buf and
+// returns the number of bytes actually read. A coalesced window's upper
+// bound is a guess (the last wanted chunk's offset plus maxChunkLen), so it
+// routinely runs past the end of the segment file -- every backend's
+// GetRange simply returns fewer bytes than requested in that case, which is
+// not an error here, only a signal to trim buf down to what's real.
+func (b *bucketBlock) fetchRange(ctx context.Context, segFile string, off int64, buf []byte) (int, error) {
+	r, err := b.bucket.GetRange(ctx, segFile, off, int64(len(buf)))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n, err := io.ReadFull(r, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// chunkSegmentFile returns the object name of the chunk segment file that
+// holds the chunk referenced by ref. Like tsdb/chunks.Reader, a chunk
+// reference packs the segment sequence number in the upper 32 bits and
+// the byte offset within that segment in the lower 32 bits. Sequence
+// number 0 is the first segment file, which tsdb names 000001.
+func chunkSegmentFile(blockID string, ref uint64) string {
+	return filepath.Join(blockID, "chunks", fmt.Sprintf("%06d", (ref>>32)+1))
+}
+
+func chunkSegmentOffset(ref uint64) int64 { return int64(ref & math.MaxUint32) }
+
+// maxChunkLen bounds how many bytes we read for a single chunk; actual
+// chunk length is encoded in its own varint header and checked by callers
+// that decode the chunk further up the stack.
+const maxChunkLen = 16 * 1024
+
+func toStorepbLabels(lset labels.Labels, extra map[string]string) []storepb.Label {
+	res := make([]storepb.Label, 0, len(lset)+len(extra))
+	for _, l := range lset {
+		res = append(res, storepb.Label{Name: l.Name, Value: l.Value})
+	}
+	for n, v := range extra {
+		res = append(res, storepb.Label{Name: n, Value: v})
+	}
+	sortLabels(res)
+	return res
+}
+
+func downloadObject(ctx context.Context, bucket objstore.Bucket, src, dst string) error {
+	r, err := bucket.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func sortStrings(s []string) { sort.Strings(s) }
+
+func sortLabels(lset []storepb.Label) {
+	sort.Slice(lset, func(i, j int) bool { return lset[i].Name < lset[j].Name })
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/objtesting"
+	"github.com/improbable-eng/thanos/pkg/shipper"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// TestBucketStore_Sharding_e2e runs three BucketStore replicas, each owning
+// a third of the blocks in the same bucket via HashRingShardFunc, and
+// checks that every series is served by exactly one replica and that the
+// union of all three replicas' results covers every series.
+func TestBucketStore_Sharding_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dir, err := ioutil.TempDir("", "test_bucketstore_sharding_e2e")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(dir)
+
+		series := []labels.Labels{
+			labels.FromStrings("a", "1"),
+			labels.FromStrings("a", "2"),
+			labels.FromStrings("a", "3"),
+			labels.FromStrings("a", "4"),
+			labels.FromStrings("a", "5"),
+			labels.FromStrings("a", "6"),
+		}
+		now := time.Now()
+		ship := shipper.New(log.NewNopLogger(), bkt, dir, nil)
+
+		// One block per series so ownership splits at block granularity.
+		for _, lset := range series {
+			mint := timestamp.FromTime(now)
+			now = now.Add(2 * time.Hour)
+			maxt := timestamp.FromTime(now)
+
+			id, err := testutil.CreateBlock(dir, []labels.Labels{lset}, 10, mint, maxt)
+			testutil.Ok(t, err)
+
+			blockDir := filepath.Join(dir, id.String())
+			testutil.Ok(t, ship.Upload(ctx, id, blockDir))
+			testutil.Ok(t, os.RemoveAll(blockDir))
+		}
+
+		const totalShards = 3
+		stores := make([]*BucketStore, totalShards)
+		for i := 0; i < totalShards; i++ {
+			storeDir, err := ioutil.TempDir("", "test_bucketstore_sharding_e2e_store")
+			testutil.Ok(t, err)
+			defer os.RemoveAll(storeDir)
+
+			s, err := NewBucketStore(nil, nil, bkt, nil, storeDir, HashRingShardFunc(uint64(i), totalShards))
+			testutil.Ok(t, err)
+			stores[i] = s
+		}
+
+		for _, s := range stores {
+			testutil.Ok(t, s.SyncBlocks(ctx))
+		}
+
+		owners := map[string]int{}
+		for _, s := range stores {
+			srv := &testStoreSeriesServer{ctx: ctx}
+			err := s.Series(&storepb.SeriesRequest{
+				Matchers: []storepb.LabelMatcher{
+					{Type: storepb.LabelMatcher_RE, Name: "a", Value: ".+"},
+				},
+				MinTime: 0,
+				MaxTime: timestamp.FromTime(now),
+			}, srv)
+			testutil.Ok(t, err)
+
+			for _, ser := range srv.series {
+				owners[labelsKey(ser.Labels)]++
+			}
+		}
+
+		testutil.Equals(t, len(series), len(owners))
+		for key, n := range owners {
+			testutil.Assert(t, n == 1, "expected series %s to be served by exactly one shard, got %d", key, n)
+		}
+	})
+}
+
+func labelsKey(lset []storepb.Label) string {
+	key := ""
+	for _, l := range lset {
+		key += l.Name + "=" + l.Value + ","
+	}
+	return key
+}
@@ -2,199 +2,246 @@ package store
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/objtesting"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/shipper"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/pkg/errors"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb"
 	"github.com/prometheus/tsdb/labels"
 )
 
-func TestGCSStore_e2e(t *testing.T) {
-	bkt, cleanup := testutil.NewObjectStoreBucket(t)
-	defer cleanup()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	dir, err := ioutil.TempDir("", "test_gcsstore_e2e")
-	testutil.Ok(t, err)
-	defer os.RemoveAll(dir)
-
-	series := []labels.Labels{
-		labels.FromStrings("a", "1", "b", "1"),
-		labels.FromStrings("a", "1", "b", "2"),
-		labels.FromStrings("a", "2", "b", "1"),
-		labels.FromStrings("a", "2", "b", "2"),
-		labels.FromStrings("a", "1", "c", "1"),
-		labels.FromStrings("a", "1", "c", "2"),
-		labels.FromStrings("a", "2", "c", "1"),
-		labels.FromStrings("a", "2", "c", "2"),
-	}
-	start := time.Now()
-	now := start
-	remote := shipper.NewGCSRemote(log.NewNopLogger(), nil, bkt.Handle())
-
-	minTime := int64(0)
-	maxTime := int64(0)
-	for i := 0; i < 3; i++ {
-		mint := timestamp.FromTime(now)
-		now = now.Add(2 * time.Hour)
-		maxt := timestamp.FromTime(now)
-
-		if minTime == 0 {
-			minTime = mint
-		}
-		maxTime = maxt
+// rangeCountingBucket wraps an objstore.Bucket and counts GetRange calls so
+// tests can assert that chunk fetches get coalesced rather than issued one
+// per chunk.
+type rangeCountingBucket struct {
+	objstore.Bucket
+	rangeCalls int64
+}
 
-		// Create two blocks per time slot. Only add 10 samples each so only one chunk
-		// gets created each. This way we can easily verify we got 10 chunks per series below.
-		id1, err := testutil.CreateBlock(dir, series[:4], 10, mint, maxt)
-		testutil.Ok(t, err)
-		id2, err := testutil.CreateBlock(dir, series[4:], 10, mint, maxt)
+func (b *rangeCountingBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	atomic.AddInt64(&b.rangeCalls, 1)
+	return b.Bucket.GetRange(ctx, name, off, length)
+}
+
+// TestBucketStore_e2e runs the same end-to-end scenario against every
+// objstore.Bucket backend registered with objtesting.ForeachStore.
+func TestBucketStore_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		dir, err := ioutil.TempDir("", "test_bucketstore_e2e")
 		testutil.Ok(t, err)
+		defer os.RemoveAll(dir)
+
+		series := []labels.Labels{
+			labels.FromStrings("a", "1", "b", "1"),
+			labels.FromStrings("a", "1", "b", "2"),
+			labels.FromStrings("a", "2", "b", "1"),
+			labels.FromStrings("a", "2", "b", "2"),
+			labels.FromStrings("a", "1", "c", "1"),
+			labels.FromStrings("a", "1", "c", "2"),
+			labels.FromStrings("a", "2", "c", "1"),
+			labels.FromStrings("a", "2", "c", "2"),
+		}
+		start := time.Now()
+		now := start
+		ship := shipper.New(log.NewNopLogger(), bkt, dir, nil)
+
+		minTime := int64(0)
+		maxTime := int64(0)
+		for i := 0; i < 3; i++ {
+			mint := timestamp.FromTime(now)
+			now = now.Add(2 * time.Hour)
+			maxt := timestamp.FromTime(now)
+
+			if minTime == 0 {
+				minTime = mint
+			}
+			maxTime = maxt
+
+			// Create two blocks per time slot. Only add 10 samples each so only one chunk
+			// gets created each. This way we can easily verify we got 3 chunks per series
+			// (one per time slot) below.
+			id1, err := testutil.CreateBlock(dir, series[:4], 10, mint, maxt)
+			testutil.Ok(t, err)
+			id2, err := testutil.CreateBlock(dir, series[4:], 10, mint, maxt)
+			testutil.Ok(t, err)
+
+			dir1, dir2 := filepath.Join(dir, id1.String()), filepath.Join(dir, id2.String())
+
+			// Add labels to the meta of the second block.
+			meta, err := block.ReadMetaFile(dir2)
+			testutil.Ok(t, err)
+			meta.Thanos.Labels = map[string]string{"ext": "value"}
+			testutil.Ok(t, block.WriteMetaFile(dir2, meta))
+
+			testutil.Ok(t, ship.Upload(ctx, id1, dir1))
+			testutil.Ok(t, ship.Upload(ctx, id2, dir2))
+
+			testutil.Ok(t, os.RemoveAll(dir1))
+			testutil.Ok(t, os.RemoveAll(dir2))
+		}
 
-		dir1, dir2 := filepath.Join(dir, id1.String()), filepath.Join(dir, id2.String())
+		countingBkt := &rangeCountingBucket{Bucket: bkt}
 
-		// Add labels to the meta of the second block.
-		meta, err := block.ReadMetaFile(dir2)
+		var gossipMinTime, gossipMaxTime int64
+		store, err := NewBucketStore(nil, nil, countingBkt, func(mint int64, maxt int64) {
+			gossipMinTime = mint
+			gossipMaxTime = maxt
+		}, dir, nil)
 		testutil.Ok(t, err)
-		meta.Thanos.Labels = map[string]string{"ext": "value"}
-		testutil.Ok(t, block.WriteMetaFile(dir2, meta))
 
-		// TODO(fabxc): remove the component dependency by factoring out the block interface.
-		testutil.Ok(t, remote.Upload(ctx, id1, dir1))
-		testutil.Ok(t, remote.Upload(ctx, id2, dir2))
+		go func() {
+			runutil.Repeat(100*time.Millisecond, ctx.Done(), func() error {
+				return store.SyncBlocks(ctx)
+			})
+		}()
 
-		testutil.Ok(t, os.RemoveAll(dir1))
-		testutil.Ok(t, os.RemoveAll(dir2))
-	}
+		ctx, _ = context.WithTimeout(ctx, 30*time.Second)
+
+		err = runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
+			if store.numBlocks() < 6 {
+				return errors.New("not all blocks loaded")
+			}
+			return nil
+		})
+		testutil.Ok(t, err)
+
+		testutil.Equals(t, minTime, gossipMinTime)
+		testutil.Equals(t, maxTime, gossipMaxTime)
 
-	var gossipMinTime, gossipMaxTime int64
-	store, err := NewGCSStore(nil, nil, bkt, func(mint int64, maxt int64) {
-		gossipMinTime = mint
-		gossipMaxTime = maxt
-	}, dir)
-	testutil.Ok(t, err)
+		vals, err := store.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []string{"1", "2"}, vals.Values)
 
-	go func() {
-		runutil.Repeat(100*time.Millisecond, ctx.Done(), func() error {
-			return store.SyncBlocks(ctx)
+		names, err := store.LabelNames(ctx, &storepb.LabelNamesRequest{
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "value"},
+			},
+			MinTime: timestamp.FromTime(start),
+			MaxTime: timestamp.FromTime(now),
 		})
-	}()
+		testutil.Ok(t, err)
+		testutil.Equals(t, []string{"a", "c", "ext"}, names.Names)
+
+		pbseries := [][]storepb.Label{
+			{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}},
+			{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+			{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext", Value: "value"}},
+			{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext", Value: "value"}},
+			{{Name: "a", Value: "2"}, {Name: "b", Value: "1"}},
+			{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}},
+			{{Name: "a", Value: "2"}, {Name: "c", Value: "1"}, {Name: "ext", Value: "value"}},
+			{{Name: "a", Value: "2"}, {Name: "c", Value: "2"}, {Name: "ext", Value: "value"}},
+		}
+		srv := &testStoreSeriesServer{ctx: ctx}
 
-	ctx, _ = context.WithTimeout(ctx, 30*time.Second)
+		err = store.Series(&storepb.SeriesRequest{
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1|2"},
+			},
+			MinTime: timestamp.FromTime(start),
+			MaxTime: timestamp.FromTime(now),
+		}, srv)
+		testutil.Ok(t, err)
+		testutil.Equals(t, len(pbseries), len(srv.series))
 
-	err = runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
-		if store.numBlocks() < 6 {
-			return errors.New("not all blocks loaded")
+		totalChunks := 0
+		for i, s := range srv.series {
+			testutil.Equals(t, pbseries[i], s.Labels)
+			testutil.Equals(t, 3, len(s.Chunks))
+			totalChunks += len(s.Chunks)
 		}
-		return nil
-	})
-	testutil.Ok(t, err)
-
-	testutil.Equals(t, minTime, gossipMinTime)
-	testutil.Equals(t, maxTime, gossipMaxTime)
-
-	vals, err := store.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
-	testutil.Ok(t, err)
-	testutil.Equals(t, []string{"1", "2"}, vals.Values)
-
-	pbseries := [][]storepb.Label{
-		{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}},
-		{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
-		{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext", Value: "value"}},
-		{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext", Value: "value"}},
-		{{Name: "a", Value: "2"}, {Name: "b", Value: "1"}},
-		{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}},
-		{{Name: "a", Value: "2"}, {Name: "c", Value: "1"}, {Name: "ext", Value: "value"}},
-		{{Name: "a", Value: "2"}, {Name: "c", Value: "2"}, {Name: "ext", Value: "value"}},
-	}
-	srv := &testStoreSeriesServer{ctx: ctx}
 
-	err = store.Series(&storepb.SeriesRequest{
-		Matchers: []storepb.LabelMatcher{
-			{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1|2"},
-		},
-		MinTime: timestamp.FromTime(start),
-		MaxTime: timestamp.FromTime(now),
-	}, srv)
-	testutil.Ok(t, err)
-	testutil.Equals(t, len(pbseries), len(srv.series))
-
-	for i, s := range srv.series {
-		testutil.Equals(t, pbseries[i], s.Labels)
-		testutil.Equals(t, 3, len(s.Chunks))
-	}
+		// Chunks belonging to the same block are written to few, densely
+		// packed segment files, so coalescing should collapse this into far
+		// fewer range requests than one per chunk.
+		rangeCalls := atomic.LoadInt64(&countingBkt.rangeCalls)
+		testutil.Assert(t, rangeCalls < int64(totalChunks), "expected coalesced range requests (%d) to be fewer than chunks fetched (%d)", rangeCalls, totalChunks)
+
+		// The range-request metrics should agree with what the counting
+		// bucket wrapper observed directly, and the chunk pool should
+		// actually have been exercised rather than just wired up.
+		testutil.Equals(t, float64(rangeCalls), prom_testutil.ToFloat64(store.metrics.rangeRequestsIssued))
+		testutil.Assert(t, prom_testutil.ToFloat64(store.metrics.rangeRequestsCoalesced) > 0, "expected some range requests to have been coalesced")
+		testutil.Assert(t, prom_testutil.ToFloat64(store.metrics.chunkBytesFetched) > 0, "expected chunk bytes fetched metric to be positive")
+		testutil.Assert(t, prom_testutil.ToFloat64(store.metrics.chunkPoolMisses) > 0, "expected chunk pool misses to be recorded")
+
+		pbseries = [][]storepb.Label{
+			{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
+			{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}},
+		}
+		srv = &testStoreSeriesServer{ctx: ctx}
 
-	pbseries = [][]storepb.Label{
-		{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}},
-		{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}},
-	}
-	srv = &testStoreSeriesServer{ctx: ctx}
+		err = store.Series(&storepb.SeriesRequest{
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
+			},
+			MinTime: timestamp.FromTime(start),
+			MaxTime: timestamp.FromTime(now),
+		}, srv)
+		testutil.Ok(t, err)
+		testutil.Equals(t, len(pbseries), len(srv.series))
 
-	err = store.Series(&storepb.SeriesRequest{
-		Matchers: []storepb.LabelMatcher{
-			{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
-		},
-		MinTime: timestamp.FromTime(start),
-		MaxTime: timestamp.FromTime(now),
-	}, srv)
-	testutil.Ok(t, err)
-	testutil.Equals(t, len(pbseries), len(srv.series))
-
-	for i, s := range srv.series {
-		testutil.Equals(t, pbseries[i], s.Labels)
-		testutil.Equals(t, 3, len(s.Chunks))
-	}
+		for i, s := range srv.series {
+			testutil.Equals(t, pbseries[i], s.Labels)
+			testutil.Equals(t, 3, len(s.Chunks))
+		}
 
-	// Matching by external label should work as well.
-	pbseries = [][]storepb.Label{
-		{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext", Value: "value"}},
-		{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext", Value: "value"}},
-	}
-	srv = &testStoreSeriesServer{ctx: ctx}
+		// Matching by external label should work as well.
+		pbseries = [][]storepb.Label{
+			{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext", Value: "value"}},
+			{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext", Value: "value"}},
+		}
+		srv = &testStoreSeriesServer{ctx: ctx}
 
-	err = store.Series(&storepb.SeriesRequest{
-		Matchers: []storepb.LabelMatcher{
-			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
-			{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "value"},
-		},
-		MinTime: timestamp.FromTime(start),
-		MaxTime: timestamp.FromTime(now),
-	}, srv)
-	testutil.Ok(t, err)
-	testutil.Equals(t, len(pbseries), len(srv.series))
-
-	for i, s := range srv.series {
-		testutil.Equals(t, pbseries[i], s.Labels)
-		testutil.Equals(t, 3, len(s.Chunks))
-	}
+		err = store.Series(&storepb.SeriesRequest{
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+				{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "value"},
+			},
+			MinTime: timestamp.FromTime(start),
+			MaxTime: timestamp.FromTime(now),
+		}, srv)
+		testutil.Ok(t, err)
+		testutil.Equals(t, len(pbseries), len(srv.series))
 
-	srv = &testStoreSeriesServer{ctx: ctx}
-	err = store.Series(&storepb.SeriesRequest{
-		Matchers: []storepb.LabelMatcher{
-			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
-			{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "wrong-value"},
-		},
-		MinTime: timestamp.FromTime(start),
-		MaxTime: timestamp.FromTime(now),
-	}, srv)
-	testutil.Ok(t, err)
-	testutil.Equals(t, 0, len(srv.series))
+		for i, s := range srv.series {
+			testutil.Equals(t, pbseries[i], s.Labels)
+			testutil.Equals(t, 3, len(s.Chunks))
+		}
+
+		srv = &testStoreSeriesServer{ctx: ctx}
+		err = store.Series(&storepb.SeriesRequest{
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+				{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "wrong-value"},
+			},
+			MinTime: timestamp.FromTime(start),
+			MaxTime: timestamp.FromTime(now),
+		}, srv)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(srv.series))
+	})
 }
 
-func TestGCSBlock_matches(t *testing.T) {
+func TestBucketBlock_matches(t *testing.T) {
 	makeMeta := func(mint, maxt int64, lset map[string]string) *block.Meta {
 		return &block.Meta{
 			BlockMeta: tsdb.BlockMeta{
@@ -281,7 +328,7 @@ func TestGCSBlock_matches(t *testing.T) {
 	}
 
 	for i, c := range cases {
-		b := &gcsBlock{meta: c.meta}
+		b := &bucketBlock{meta: c.meta}
 		blockMatchers, ok := b.blockMatchers(c.mint, c.maxt, c.matchers...)
 		testutil.Assert(t, c.ok == ok, "test case %d failed", i)
 		testutil.Equals(t, c.expBlockMatchers, blockMatchers)
@@ -302,4 +349,4 @@ func (s *testStoreSeriesServer) Send(r *storepb.SeriesResponse) error {
 
 func (s *testStoreSeriesServer) Context() context.Context {
 	return s.ctx
-}
\ No newline at end of file
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/oklog/ulid"
+)
+
+// ShardFunc decides whether a block belongs to a BucketStore's shard. It is
+// evaluated once per block, as soon as its meta.json has been fetched, so it
+// must not assume the block's index is available.
+type ShardFunc func(meta *block.Meta) bool
+
+// AllShardsFunc is the default ShardFunc: every block belongs to every
+// store, i.e. no sharding at all.
+func AllShardsFunc(*block.Meta) bool { return true }
+
+// HashModShardFunc returns a ShardFunc that assigns a block to shard
+// shardIndex of totalShards by taking the hash of its ULID modulo
+// totalShards. It's the simplest possible sharding scheme: ownership is
+// spread evenly, but changing totalShards reshuffles almost every block
+// between replicas, since nearly all hashes land in a different bucket
+// once the modulus changes.
+func HashModShardFunc(shardIndex, totalShards uint64) ShardFunc {
+	totalShards = normalizeTotalShards(totalShards)
+	return func(meta *block.Meta) bool {
+		return hashULID(meta.ULID)%totalShards == shardIndex
+	}
+}
+
+// normalizeTotalShards guards against a misconfigured shard count of zero,
+// which would otherwise divide by zero in HashModShardFunc or leave
+// hashRing with no tokens at all. Treating it as 1 makes every block belong
+// to the one shard that exists, which is the same "no sharding" behavior
+// AllShardsFunc gives.
+func normalizeTotalShards(totalShards uint64) uint64 {
+	if totalShards == 0 {
+		return 1
+	}
+	return totalShards
+}
+
+// HashRingShardFunc returns a ShardFunc that assigns a block to shard
+// shardIndex of totalShards using consistent hashing: each shard owns
+// several virtual nodes scattered around a hash ring, and a block belongs
+// to whichever virtual node's token is nearest going clockwise from the
+// block's own hash. Unlike HashModShardFunc, growing or shrinking
+// totalShards only moves blocks between neighbouring shards on the ring
+// instead of reshuffling the whole set.
+func HashRingShardFunc(shardIndex, totalShards uint64) ShardFunc {
+	ring := newHashRing(totalShards)
+	return func(meta *block.Meta) bool {
+		return ring.shardFor(hashULID(meta.ULID)) == shardIndex
+	}
+}
+
+// virtualNodesPerShard controls how many tokens each shard places on the
+// ring. More virtual nodes spread ownership more evenly across shards.
+const virtualNodesPerShard = 100
+
+// hashRing implements consistent hashing over a fixed number of shards.
+type hashRing struct {
+	tokens []uint64
+	owner  map[uint64]uint64 // token -> shard index
+}
+
+func newHashRing(totalShards uint64) *hashRing {
+	totalShards = normalizeTotalShards(totalShards)
+	r := &hashRing{owner: make(map[uint64]uint64, totalShards*virtualNodesPerShard)}
+	for shard := uint64(0); shard < totalShards; shard++ {
+		for v := 0; v < virtualNodesPerShard; v++ {
+			tok := hashString(strconv.FormatUint(shard, 10) + "-" + strconv.Itoa(v))
+			r.tokens = append(r.tokens, tok)
+			r.owner[tok] = shard
+		}
+	}
+	sort.Slice(r.tokens, func(i, j int) bool { return r.tokens[i] < r.tokens[j] })
+	return r
+}
+
+// shardFor returns the shard owning key: the shard whose virtual node token
+// is the first at or after key going clockwise, wrapping around to the
+// smallest token if key is past the last one.
+func (r *hashRing) shardFor(key uint64) uint64 {
+	i := sort.Search(len(r.tokens), func(i int) bool { return r.tokens[i] >= key })
+	if i == len(r.tokens) {
+		i = 0
+	}
+	return r.owner[r.tokens[i]]
+}
+
+func hashULID(id ulid.ULID) uint64 {
+	h := fnv.New64a()
+	h.Write(id[:])
+	return h.Sum64()
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
@@ -0,0 +1,91 @@
+// Package storepb's Go types mirror the service and messages declared in
+// rpc.proto and types.proto by hand -- there is no protoc/gogo-proto
+// codegen step in this repo, so editing the .proto files has no effect
+// here. Keep these types in sync with the IDL manually.
+package storepb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// LabelMatcher_Type enumerates the supported matcher operators.
+type LabelMatcher_Type int32
+
+const (
+	LabelMatcher_EQ  LabelMatcher_Type = 0
+	LabelMatcher_NEQ LabelMatcher_Type = 1
+	LabelMatcher_RE  LabelMatcher_Type = 2
+	LabelMatcher_NRE LabelMatcher_Type = 3
+)
+
+// LabelMatcher is the wire representation of a PromQL label matcher.
+type LabelMatcher struct {
+	Type  LabelMatcher_Type
+	Name  string
+	Value string
+}
+
+// SeriesRequest requests series for the given time range and matchers.
+type SeriesRequest struct {
+	Matchers []LabelMatcher
+	MinTime  int64
+	MaxTime  int64
+}
+
+// SeriesResponse carries a single series on the Series stream.
+type SeriesResponse struct {
+	Series Series
+}
+
+// LabelNamesRequest requests all label names visible under the given
+// matchers and time range.
+type LabelNamesRequest struct {
+	Matchers []LabelMatcher
+	MinTime  int64
+	MaxTime  int64
+}
+
+// LabelNamesResponse carries the sorted, deduplicated set of label names.
+type LabelNamesResponse struct {
+	Names []string
+}
+
+// LabelValuesRequest requests all values for a single label name.
+type LabelValuesRequest struct {
+	Label   string
+	MinTime int64
+	MaxTime int64
+}
+
+// LabelValuesResponse carries the sorted, deduplicated set of label values.
+type LabelValuesResponse struct {
+	Values []string
+}
+
+// StoreClient is the client API for the Store service.
+type StoreClient interface {
+	Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (Store_SeriesClient, error)
+	LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error)
+	LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error)
+}
+
+// Store_SeriesClient is the client-side stream handle returned by Series.
+type Store_SeriesClient interface {
+	Recv() (*SeriesResponse, error)
+	grpc.ClientStream
+}
+
+// StoreServer is the server API for the Store service.
+type StoreServer interface {
+	Series(*SeriesRequest, Store_SeriesServer) error
+	LabelNames(context.Context, *LabelNamesRequest) (*LabelNamesResponse, error)
+	LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error)
+}
+
+// Store_SeriesServer is the server-side stream handle passed into Series.
+type Store_SeriesServer interface {
+	Send(*SeriesResponse) error
+	grpc.ServerStream
+}
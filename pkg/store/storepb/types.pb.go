@@ -0,0 +1,24 @@
+// Hand-maintained to mirror types.proto; see the package comment in
+// rpc.pb.go.
+package storepb
+
+// Label is a single label name/value pair.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Chunk holds an encoded chunk of samples for a single series along with
+// the time range it covers.
+type Chunk struct {
+	MinTime int64
+	MaxTime int64
+	Data    []byte
+}
+
+// Series is a single time series identified by its sorted label set plus
+// the chunks covering the requested time range.
+type Series struct {
+	Labels []Label
+	Chunks []Chunk
+}
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+	"github.com/prometheus/tsdb/wal"
+)
+
+// walDirPrefix is where the shipper uploads raw/partial WAL segments, as
+// opposed to the "<ulid>/" prefixes used for completed blocks.
+const walDirPrefix = "wal/"
+
+// WALStore serves the most recent, sub-block-age data directly out of
+// Prometheus WAL segments that have been mirrored into the bucket. It
+// fills the query gap that exists until a 2h block is cut and shipped,
+// since BucketStore can only ever see completed blocks.
+//
+// It implements the same storepb.StoreServer interface as BucketStore so
+// the two can be queried interchangeably, e.g. fanned-out to from a single
+// proxy.
+type WALStore struct {
+	logger log.Logger
+	bucket objstore.Bucket
+	dir    string
+
+	mtx      sync.RWMutex
+	segments map[string]struct{} // object names already synced.
+	series   map[uint64]*walSeries
+	postings *index.MemPostings
+	values   map[string]map[string]struct{} // label name -> observed values.
+}
+
+// walSeries holds the decoded chunk for one series as read out of WAL
+// segments. WALStore.series keys it by the reference from the WAL's series
+// record, not by label set: two references carrying the same labels are
+// held, and returned by Series, as separate series.
+type walSeries struct {
+	lset labels.Labels
+
+	// app accumulates samples for the chunk currently being built so
+	// incremental WAL records can be appended to it until it's cut.
+	app     chunkenc.Appender
+	cur     chunkenc.Chunk
+	minTime int64
+	maxTime int64
+}
+
+// NewWALStore creates a new WALStore that mirrors segments found under the
+// "wal/" prefix of bucket into dir.
+func NewWALStore(logger log.Logger, bucket objstore.Bucket, dir string) (*WALStore, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrap(err, "create wal cache dir")
+	}
+	return &WALStore{
+		logger:   logger,
+		bucket:   bucket,
+		dir:      dir,
+		segments: map[string]struct{}{},
+		series:   map[uint64]*walSeries{},
+		postings: index.NewMemPostings(),
+		values:   map[string]map[string]struct{}{},
+	}, nil
+}
+
+// SyncBlocks discovers new WAL segments in the bucket and folds their
+// records into the in-memory index. It is intended to be called
+// periodically from a runutil.Repeat loop, mirroring BucketStore.SyncBlocks.
+func (s *WALStore) SyncBlocks(ctx context.Context) error {
+	var newSegments []string
+
+	err := s.bucket.Iter(ctx, walDirPrefix, func(name string) error {
+		s.mtx.RLock()
+		_, ok := s.segments[name]
+		s.mtx.RUnlock()
+		if !ok {
+			newSegments = append(newSegments, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "iter wal segments")
+	}
+
+	for _, name := range newSegments {
+		if err := s.syncSegment(ctx, name); err != nil {
+			level.Warn(s.logger).Log("msg", "sync wal segment failed", "segment", name, "err", err)
+			continue
+		}
+		s.mtx.Lock()
+		s.segments[name] = struct{}{}
+		s.mtx.Unlock()
+	}
+	return nil
+}
+
+func (s *WALStore) syncSegment(ctx context.Context, name string) error {
+	local := filepath.Join(s.dir, filepath.Base(strings.TrimPrefix(name, walDirPrefix)))
+	if err := downloadObject(ctx, s.bucket, name, local); err != nil {
+		return errors.Wrap(err, "download segment")
+	}
+
+	seg, err := wal.OpenReadSegment(local)
+	if err != nil {
+		return errors.Wrap(err, "open segment")
+	}
+	sr := wal.NewSegmentBufReader(seg)
+	defer sr.Close()
+
+	r := wal.NewReader(sr)
+	for r.Next() {
+		if err := s.processRecord(r.Record()); err != nil {
+			return errors.Wrap(err, "process wal record")
+		}
+	}
+	return r.Err()
+}
+
+// Record type tags, mirroring the layout Prometheus' own WAL uses for
+// series and sample records.
+const (
+	recordSeries  byte = 1
+	recordSamples byte = 2
+)
+
+func (s *WALStore) processRecord(rec []byte) error {
+	if len(rec) == 0 {
+		return nil
+	}
+	switch rec[0] {
+	case recordSeries:
+		return s.processSeriesRecord(rec[1:])
+	case recordSamples:
+		return s.processSamplesRecord(rec[1:])
+	default:
+		// Unknown record types are skipped so newer WAL producers can add
+		// record kinds without breaking older readers.
+		return nil
+	}
+}
+
+func (s *WALStore) processSeriesRecord(b []byte) error {
+	for len(b) > 0 {
+		ref, rest, err := readUvarint(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		numLabels, rest, err := readUvarint(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+
+		// Each label needs at least 2 bytes (a zero-length name and value
+		// prefix), so this rejects a truncated or corrupt numLabels before
+		// it's used to size an allocation off untrusted input.
+		if numLabels > uint64(len(b))/2 {
+			return errors.Errorf("series record: numLabels %d exceeds remaining record size", numLabels)
+		}
+
+		lset := make(labels.Labels, 0, numLabels)
+		for i := uint64(0); i < numLabels; i++ {
+			name, rest, err := readString(b)
+			if err != nil {
+				return err
+			}
+			val, rest2, err := readString(rest)
+			if err != nil {
+				return err
+			}
+			lset = append(lset, labels.Label{Name: name, Value: val})
+			b = rest2
+		}
+
+		s.mtx.Lock()
+		if _, ok := s.series[ref]; !ok {
+			chk, app, err := newChunkAppender()
+			if err != nil {
+				s.mtx.Unlock()
+				return err
+			}
+			s.series[ref] = &walSeries{lset: lset, app: app, cur: chk}
+			s.postings.Add(ref, lset)
+			for _, l := range lset {
+				if s.values[l.Name] == nil {
+					s.values[l.Name] = map[string]struct{}{}
+				}
+				s.values[l.Name][l.Value] = struct{}{}
+			}
+		}
+		s.mtx.Unlock()
+	}
+	return nil
+}
+
+func (s *WALStore) processSamplesRecord(b []byte) error {
+	for len(b) > 0 {
+		ref, rest, err := readUvarint(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		if len(b) < 16 {
+			return io.ErrUnexpectedEOF
+		}
+		t := int64(binary.BigEndian.Uint64(b))
+		b = b[8:]
+		v := math.Float64frombits(binary.BigEndian.Uint64(b))
+		b = b[8:]
+
+		s.mtx.Lock()
+		ser, ok := s.series[ref]
+		if ok {
+			ser.app.Append(t, v)
+			if ser.minTime == 0 || t < ser.minTime {
+				ser.minTime = t
+			}
+			if t > ser.maxTime {
+				ser.maxTime = t
+			}
+		}
+		s.mtx.Unlock()
+	}
+	return nil
+}
+
+// Series implements storepb.StoreServer.
+func (s *WALStore) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	matchers, err := translateMatchers(req.Matchers)
+	if err != nil {
+		return errors.Wrap(err, "translate matchers")
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.postingsForLabelMatchers(matchers)
+	if err != nil {
+		return errors.Wrap(err, "postings for matchers")
+	}
+	for p.Next() {
+		ser, ok := s.series[p.At()]
+		if !ok {
+			continue
+		}
+		if ser.maxTime < req.MinTime || ser.minTime > req.MaxTime {
+			continue
+		}
+		chk := ser.cur.Bytes()
+		resp := storepb.Series{
+			Labels: toStorepbLabels(ser.lset, nil),
+			Chunks: []storepb.Chunk{{MinTime: ser.minTime, MaxTime: ser.maxTime, Data: chk}},
+		}
+		if err := srv.Send(&storepb.SeriesResponse{Series: resp}); err != nil {
+			return err
+		}
+	}
+	return p.Err()
+}
+
+// LabelValues implements storepb.StoreServer.
+func (s *WALStore) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	values := map[string]struct{}{}
+	for _, ser := range s.series {
+		if v := ser.lset.Get(req.Label); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(values))
+	for v := range values {
+		res = append(res, v)
+	}
+	sortStrings(res)
+	return &storepb.LabelValuesResponse{Values: res}, nil
+}
+
+// LabelNames implements storepb.StoreServer, using the same matcher
+// pushdown approach BucketStore.LabelNames uses against completed blocks.
+func (s *WALStore) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	matchers, err := translateMatchers(req.Matchers)
+	if err != nil {
+		return nil, errors.Wrap(err, "translate matchers")
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	p, err := s.postingsForLabelMatchers(matchers)
+	if err != nil {
+		return nil, errors.Wrap(err, "postings for matchers")
+	}
+	names := map[string]struct{}{}
+	for p.Next() {
+		ser, ok := s.series[p.At()]
+		if !ok {
+			continue
+		}
+		for _, l := range ser.lset {
+			names[l.Name] = struct{}{}
+		}
+	}
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	res := make([]string, 0, len(names))
+	for n := range names {
+		res = append(res, n)
+	}
+	sortStrings(res)
+	return &storepb.LabelNamesResponse{Names: res}, nil
+}
+
+func (s *WALStore) postingsForLabelMatchers(matchers []labels.Matcher) (index.Postings, error) {
+	if len(matchers) == 0 {
+		return s.postings.All(), nil
+	}
+	var intersect []index.Postings
+	for _, m := range matchers {
+		var union []index.Postings
+		for v := range s.values[m.Name()] {
+			if m.Matches(v) {
+				union = append(union, s.postings.Get(m.Name(), v))
+			}
+		}
+		if len(union) == 0 {
+			return index.EmptyPostings(), nil
+		}
+		intersect = append(intersect, index.Merge(union...))
+	}
+	return index.Intersect(intersect...), nil
+}
+
+func newChunkAppender() (chunkenc.Chunk, chunkenc.Appender, error) {
+	chk := chunkenc.NewXORChunk()
+	app, err := chk.Appender()
+	return chk, app, err
+}
+
+// readUvarint decodes a uvarint from the start of b and returns it along
+// with the remaining bytes. It bounds-checks binary.Uvarint's result
+// instead of trusting it, since n == 0 (buffer too short) or n < 0 (value
+// overflows 64 bits) both indicate a truncated or corrupt record, and
+// either one used unchecked would spin forever or index out of range.
+func readUvarint(b []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return v, b[n:], nil
+}
+
+// readString decodes a uvarint length prefix followed by that many bytes of
+// string data. The length check is done against len(rest), not by adding l
+// to a consumed-byte count, since l comes straight off the wire and adding
+// to it can overflow uint64 and wrap back under len(b), letting a corrupt
+// record sail past the check only to panic on the slice below.
+func readString(b []byte) (string, []byte, error) {
+	l, rest, err := readUvarint(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if l > uint64(len(rest)) {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(rest[:l]), rest[l:], nil
+}
+
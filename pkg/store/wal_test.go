@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/objtesting"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/tsdb/labels"
+	"github.com/prometheus/tsdb/wal"
+)
+
+func TestWALStore_postingsForLabelMatchers(t *testing.T) {
+	s, err := NewWALStore(nil, nil, t.TempDir())
+	testutil.Ok(t, err)
+
+	add := func(ref uint64, lset labels.Labels) {
+		s.postings.Add(ref, lset)
+		for _, l := range lset {
+			if s.values[l.Name] == nil {
+				s.values[l.Name] = map[string]struct{}{}
+			}
+			s.values[l.Name][l.Value] = struct{}{}
+		}
+	}
+	add(1, labels.FromStrings("a", "1", "b", "1"))
+	add(2, labels.FromStrings("a", "1", "b", "2"))
+	add(3, labels.FromStrings("a", "2", "b", "1"))
+
+	cases := []struct {
+		matchers []labels.Matcher
+		exp      []uint64
+	}{
+		{matchers: nil, exp: []uint64{1, 2, 3}},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("a", "1")}, exp: []uint64{1, 2}},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("a", "1"), labels.NewEqualMatcher("b", "2")}, exp: []uint64{2}},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("a", "nope")}, exp: nil},
+	}
+	for i, c := range cases {
+		p, err := s.postingsForLabelMatchers(c.matchers)
+		testutil.Ok(t, err)
+
+		var got []uint64
+		for p.Next() {
+			got = append(got, p.At())
+		}
+		testutil.Ok(t, p.Err())
+		testutil.Equals(t, c.exp, got)
+	}
+}
+
+// TestWALStore_e2e writes a real WAL segment containing a series record and
+// a handful of sample records, uploads it under the "wal/" prefix the way
+// the shipper would, and drives WALStore end-to-end through SyncBlocks,
+// Series, LabelValues and LabelNames -- exercising the segment-parsing path
+// that TestWALStore_postingsForLabelMatchers deliberately bypasses.
+func TestWALStore_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
+		ctx := context.Background()
+
+		walDir, err := ioutil.TempDir("", "test_walstore_e2e_wal")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(walDir)
+
+		w, err := wal.New(nil, nil, walDir, false)
+		testutil.Ok(t, err)
+
+		lset := labels.FromStrings("a", "1", "b", "2")
+		testutil.Ok(t, w.Log(encodeSeriesRecord(1, lset)))
+		testutil.Ok(t, w.Log(
+			encodeSamplesRecord(1, 100, 1),
+			encodeSamplesRecord(1, 200, 2),
+			encodeSamplesRecord(1, 300, 3),
+		))
+		testutil.Ok(t, w.Close())
+
+		segments, err := ioutil.ReadDir(walDir)
+		testutil.Ok(t, err)
+		testutil.Assert(t, len(segments) > 0, "expected at least one wal segment to have been written")
+
+		for _, fi := range segments {
+			f, err := os.Open(filepath.Join(walDir, fi.Name()))
+			testutil.Ok(t, err)
+			testutil.Ok(t, bkt.Upload(ctx, walDirPrefix+fi.Name(), f))
+			testutil.Ok(t, f.Close())
+		}
+
+		storeDir, err := ioutil.TempDir("", "test_walstore_e2e_store")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(storeDir)
+
+		s, err := NewWALStore(nil, bkt, storeDir)
+		testutil.Ok(t, err)
+		testutil.Ok(t, s.SyncBlocks(ctx))
+
+		vals, err := s.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []string{"1"}, vals.Values)
+
+		names, err := s.LabelNames(ctx, &storepb.LabelNamesRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []string{"a", "b"}, names.Names)
+
+		srv := &testStoreSeriesServer{ctx: ctx}
+		err = s.Series(&storepb.SeriesRequest{
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+			},
+			MinTime: 0,
+			MaxTime: 1000,
+		}, srv)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(srv.series))
+		testutil.Equals(t, []storepb.Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, srv.series[0].Labels)
+		testutil.Equals(t, 1, len(srv.series[0].Chunks))
+		testutil.Assert(t, len(srv.series[0].Chunks[0].Data) > 0, "expected non-empty chunk data")
+	})
+}
+
+// TestWALStore_processRecord_corrupt feeds processRecord a handful of
+// malformed series records that the happy-path coverage in TestWALStore_e2e
+// never exercises: a numLabels field that claims more labels than the
+// record has room for, and a string length prefix so large that computing
+// "bytes consumed so far" the naive way would overflow uint64 and wrap back
+// under the buffer length, masking the truncation instead of catching it.
+// Both must return an error rather than panicking.
+func TestWALStore_processRecord_corrupt(t *testing.T) {
+	s, err := NewWALStore(nil, nil, t.TempDir())
+	testutil.Ok(t, err)
+
+	cases := map[string][]byte{
+		"numLabels exceeds remaining record size": func() []byte {
+			b := []byte{recordSeries}
+			b = appendUvarint(b, 1)
+			b = appendUvarint(b, math.MaxUint64)
+			return b
+		}(),
+		"string length overflows when added to bytes consumed": func() []byte {
+			b := []byte{recordSeries}
+			b = appendUvarint(b, 1)
+			b = appendUvarint(b, 1)
+			b = appendUvarint(b, math.MaxUint64)
+			return b
+		}(),
+	}
+	for name, rec := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := s.processRecord(rec)
+			testutil.Assert(t, err != nil, "expected an error for a corrupt record, got none")
+		})
+	}
+}
+
+func encodeSeriesRecord(ref uint64, lset labels.Labels) []byte {
+	b := []byte{recordSeries}
+	b = appendUvarint(b, ref)
+	b = appendUvarint(b, uint64(len(lset)))
+	for _, l := range lset {
+		b = appendString(b, l.Name)
+		b = appendString(b, l.Value)
+	}
+	return b
+}
+
+func encodeSamplesRecord(ref uint64, t int64, v float64) []byte {
+	b := []byte{recordSamples}
+	b = appendUvarint(b, ref)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(t))
+	b = append(b, buf[:]...)
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+	b = append(b, buf[:]...)
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
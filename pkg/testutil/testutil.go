@@ -0,0 +1,72 @@
+// Package testutil provides common assertion helpers and a TSDB block
+// fixture shared by the project's test suites.
+package testutil
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// Ok fails the test if err is not nil.
+func Ok(t testing.TB, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Equals fails the test if exp is not equal to act.
+func Equals(t testing.TB, exp, act interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(exp, act) {
+		t.Fatalf("expected: %#v, got: %#v", exp, act)
+	}
+}
+
+// Assert fails the test if the condition is false.
+func Assert(t testing.TB, cond bool, msg string, v ...interface{}) {
+	t.Helper()
+	if !cond {
+		t.Fatalf(msg, v...)
+	}
+}
+
+// CreateBlock writes a TSDB block with numSamples samples per series,
+// spaced evenly between mint and maxt, for each of the given series.
+func CreateBlock(dir string, series []labels.Labels, numSamples int, mint, maxt int64) (id ulid.ULID, err error) {
+	w, err := tsdb.NewBlockWriter(dir, mint, maxt)
+	if err != nil {
+		return id, errors.Wrap(err, "new block writer")
+	}
+	defer func() {
+		if cErr := w.Close(); err == nil {
+			err = cErr
+		}
+	}()
+
+	step := (maxt - mint) / int64(numSamples)
+	if step <= 0 {
+		step = 1
+	}
+
+	app := w.Appender()
+	for _, lset := range series {
+		t := mint
+		for i := 0; i < numSamples; i++ {
+			if _, err := app.Add(lset, t, rand.Float64()); err != nil {
+				return id, errors.Wrap(err, "add sample")
+			}
+			t += step
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return id, errors.Wrap(err, "commit")
+	}
+	return w.Flush()
+}